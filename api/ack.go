@@ -0,0 +1,51 @@
+package api
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+)
+
+// Ack is a signed acknowledgement that a StorePayload call succeeded for
+// the message identified by ID, sent back to the pushing node's /ack
+// endpoint so it can stop retrying delivery.
+type Ack struct {
+	ID        []byte
+	SigPubKey []byte
+	Signature []byte
+}
+
+// EncodeAck gob-encodes an Ack for transport.
+func EncodeAck(a Ack) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(a); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// DecodeAck reverses EncodeAck.
+func DecodeAck(encoded []byte) Ack {
+	var a Ack
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&a); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// SendAck delivers a signed Ack to the node at url.
+func SendAck(url string, ack Ack) error {
+	encoded := EncodeAck(ack)
+
+	resp, err := http.Post(fmt.Sprintf("%s/ack", url), "application/octet-stream", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ack to %s failed with status %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/kevinburke/nacl"
+)
+
+// EncryptedPayload is the wire format used both for the copy persisted to
+// the local DataStore and for the copy pushed to remote recipient nodes.
+// RecipientBoxes holds one sealed master-key box per recipient (plus the
+// sender's own box, appended last so the sender can Retrieve its own
+// payloads); RecipientBoxIndex maps a recipient's public key (hex) to its
+// slot in RecipientBoxes. The full payload, boxes for every recipient
+// included, is what gets pushed to each remote node - a node picks out the
+// box addressed to it rather than being sent a payload of its own.
+type EncryptedPayload struct {
+	Sender            nacl.Key
+	CipherText        []byte
+	Nonce             []byte
+	RecipientBoxes    [][]byte
+	RecipientBoxIndex map[string]int
+	RecipientNonce    nacl.Nonce
+
+	// RatchetHeaders carries a RatchetHeader per recipient (hex pubkey) that
+	// the sender has a negotiated double-ratchet Session with. A recipient
+	// with no entry here was sealed under the static long-term box key.
+	RatchetHeaders map[string]RatchetHeader
+
+	// Expiry, TTL and PowNonce implement the envelope discipline: Expiry is
+	// the unix second this envelope should stop being stored, TTL is how
+	// long after creation that was set to, and PowNonce is mined so that
+	// hashing the envelope (with PowNonce zeroed) together with PowNonce
+	// yields a digest with enough leading zero bits to make flooding the
+	// network with junk envelopes expensive.
+	Expiry   uint64
+	TTL      uint32
+	PowNonce uint64
+}
+
+// EncodePayload gob-encodes an EncryptedPayload for storage or transport.
+func EncodePayload(epl EncryptedPayload) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(epl); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// DecodePayload reverses EncodePayload.
+func DecodePayload(encoded []byte) EncryptedPayload {
+	var epl EncryptedPayload
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&epl); err != nil {
+		panic(err)
+	}
+	return epl
+}
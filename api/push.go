@@ -0,0 +1,26 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Push sends an encoded payload to a remote node's /push endpoint.
+func Push(epl EncryptedPayload, url string) error {
+	encoded := EncodePayload(epl)
+
+	resp, err := http.Post(fmt.Sprintf("%s/push", url), "application/octet-stream", bytes.NewReader(encoded))
+	if err != nil {
+		log.WithField("url", url).Errorf("Unable to push payload, %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("push to %s failed with status %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package api
+
+// RatchetHeader accompanies a recipient's sealed box when the sender has a
+// negotiated double-ratchet Session with them, so the receiver knows which
+// DH ratchet step and chain position to derive the message key from.
+type RatchetHeader struct {
+	DHPub           []byte
+	PrevChainLength uint32
+	MessageIndex    uint32
+}
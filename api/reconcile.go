@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Offer advertises the digests a node currently holds, so a peer that has
+// been offline can work out what it's missing.
+type Offer struct {
+	Url     string
+	Digests [][]byte
+}
+
+// Request lists the digests a node is asking a peer to (re)send, in
+// response to an Offer.
+type Request struct {
+	Digests [][]byte
+}
+
+func EncodeOffer(o Offer) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(o); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func DecodeOffer(encoded []byte) Offer {
+	var o Offer
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&o); err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func EncodeRequest(r Request) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func DecodeRequest(encoded []byte) Request {
+	var r Request
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&r); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// SendOffer posts an Offer to a peer's /offer endpoint and returns the
+// Request it replies with, listing what the peer wants resent.
+func SendOffer(url string, offer Offer) (Request, error) {
+	encoded := EncodeOffer(offer)
+
+	resp, err := http.Post(fmt.Sprintf("%s/offer", url), "application/octet-stream", bytes.NewReader(encoded))
+	if err != nil {
+		return Request{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Request{}, fmt.Errorf("offer to %s failed with status %d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Request{}, err
+	}
+	return DecodeRequest(body), nil
+}
@@ -0,0 +1,145 @@
+package enclave
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache lets Retrieve skip a DataStore read and a pair of secretbox.Open
+// calls for payloads it's already decrypted recently - useful since a
+// Quorum node commonly re-executes the same private transaction across
+// several blocks.
+type Cache interface {
+	Get(key []byte) ([]byte, bool)
+	Set(key, value []byte)
+	Delete(key []byte)
+}
+
+// NoopCache never caches anything, for deployments that want Retrieve's
+// previous always-hit-the-DataStore behaviour.
+type NoopCache struct{}
+
+func (NoopCache) Get(key []byte) ([]byte, bool) { return nil, false }
+func (NoopCache) Set(key, value []byte)         {}
+func (NoopCache) Delete(key []byte)             {}
+
+// cache returns s.Cache, defaulting to NoopCache if it was left unset.
+func (s *Enclave) cache() Cache {
+	if s.Cache == nil {
+		return NoopCache{}
+	}
+	return s.Cache
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// LRUCache is an in-memory Cache implementation: an LRU bounded by a total
+// byte budget, with a per-entry TTL on top so a decrypted payload doesn't
+// stay hot forever. An Enclave only uses it when it is assigned to
+// Enclave.Cache; left unset, the Enclave falls back to NoopCache.
+type LRUCache struct {
+	mu sync.Mutex
+
+	maxBytes int64
+	curBytes int64
+	ttl      time.Duration
+
+	order   *list.List
+	entries map[string]*list.Element
+
+	hits, misses uint64
+}
+
+// NewLRUCache creates an LRUCache holding at most maxBytes of key+value
+// data. An entry older than ttl is dropped when it is next looked up; once
+// maxBytes is exceeded, the least recently used entries are evicted,
+// whether or not they have expired.
+func NewLRUCache(maxBytes int64, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[string(key)]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		c.misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	c.hits++
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keyStr := string(key)
+	if el, ok := c.entries[keyStr]; ok {
+		c.removeElement(el)
+	}
+
+	entry := &lruEntry{
+		key:       keyStr,
+		value:     append([]byte{}, value...),
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	c.entries[keyStr] = c.order.PushFront(entry)
+	c.curBytes += int64(len(keyStr) + len(entry.value))
+
+	c.evict()
+}
+
+func (c *LRUCache) Delete(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[string(key)]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Stats returns the cache's cumulative hit/miss counters, so operators can
+// tune its size.
+func (c *LRUCache) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *LRUCache) evict() {
+	for c.curBytes > c.maxBytes {
+		back := c.order.Back()
+		if back == nil {
+			return
+		}
+		c.removeElement(back)
+	}
+}
+
+func (c *LRUCache) removeElement(el *list.Element) {
+	entry := el.Value.(*lruEntry)
+	c.order.Remove(el)
+	delete(c.entries, entry.key)
+	c.curBytes -= int64(len(entry.key) + len(entry.value))
+}
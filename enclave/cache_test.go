@@ -0,0 +1,61 @@
+package enclave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheGetSetDelete(t *testing.T) {
+	c := NewLRUCache(1024, time.Hour)
+
+	if _, ok := c.Get([]byte("missing")); ok {
+		t.Fatal("Get on an empty cache should miss")
+	}
+
+	c.Set([]byte("key"), []byte("value"))
+	if value, ok := c.Get([]byte("key")); !ok || string(value) != "value" {
+		t.Fatalf("Get after Set = (%q, %v), want (\"value\", true)", value, ok)
+	}
+
+	c.Delete([]byte("key"))
+	if _, ok := c.Get([]byte("key")); ok {
+		t.Fatal("Get after Delete should miss")
+	}
+
+	hits, misses := c.Stats()
+	if hits != 1 || misses != 2 {
+		t.Fatalf("Stats() = (hits=%d, misses=%d), want (1, 2)", hits, misses)
+	}
+}
+
+func TestLRUCacheExpiresEntriesPastTTL(t *testing.T) {
+	c := NewLRUCache(1024, time.Millisecond)
+
+	c.Set([]byte("key"), []byte("value"))
+	time.Sleep(10 * time.Millisecond)
+
+	if _, ok := c.Get([]byte("key")); ok {
+		t.Fatal("Get should miss once the entry's TTL has elapsed")
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsedOverBudget(t *testing.T) {
+	c := NewLRUCache(int64(len("a")+len("1")+len("b")+len("2")), time.Hour)
+
+	c.Set([]byte("a"), []byte("1"))
+	c.Set([]byte("b"), []byte("2"))
+	// touching "a" makes "b" the least recently used
+	if _, ok := c.Get([]byte("a")); !ok {
+		t.Fatal("expected a cache hit for \"a\"")
+	}
+
+	// pushes curBytes back over maxBytes, forcing an eviction
+	c.Set([]byte("c"), []byte("3"))
+
+	if _, ok := c.Get([]byte("b")); ok {
+		t.Fatal("expected \"b\" to have been evicted as least recently used")
+	}
+	if _, ok := c.Get([]byte("a")); !ok {
+		t.Fatal("expected \"a\" to still be cached")
+	}
+}
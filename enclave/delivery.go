@@ -0,0 +1,173 @@
+package enclave
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/gob"
+	"encoding/hex"
+	"time"
+
+	"gitlab.com/eea/crux/api"
+	"golang.org/x/crypto/sha3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// deliveryBucket is the storage.DataStore bucket outbound MessageRecords
+// live in until they're acked.
+const deliveryBucket = "delivery"
+
+const (
+	initialBackoff = time.Second
+	maxBackoff     = time.Hour
+)
+
+// MessageRecord is a single outbound push awaiting acknowledgement. It is
+// persisted so undelivered pushes survive a node restart.
+type MessageRecord struct {
+	ID          []byte
+	Payload     []byte
+	Recipient   string // destination node url
+	NextAttempt time.Time
+	Attempts    int
+}
+
+func encodeMessageRecord(r MessageRecord) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeMessageRecord(encoded []byte) MessageRecord {
+	var r MessageRecord
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&r); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// messageID derives the MVDS-style message id for a push: the digest of the
+// ciphertext together with the recipient it's addressed to, so the same
+// payload sent to two recipients gets two distinct, independently-ackable
+// ids.
+func messageID(cipherText []byte, recipient string) []byte {
+	h := sha3.New512()
+	h.Write(cipherText)
+	h.Write([]byte(recipient))
+	return h.Sum(nil)
+}
+
+// enqueueDelivery persists a MessageRecord and attempts an immediate push,
+// leaving retries to the dispatcher if it fails.
+func (s *Enclave) enqueueDelivery(id []byte, encodedEpl []byte, url string) error {
+	record := MessageRecord{
+		ID:          id,
+		Payload:     encodedEpl,
+		Recipient:   url,
+		NextAttempt: time.Now(),
+		Attempts:    0,
+	}
+
+	if err := s.saveRecord(record); err != nil {
+		return err
+	}
+
+	s.attemptDelivery(record)
+	return nil
+}
+
+func (s *Enclave) saveRecord(record MessageRecord) error {
+	key := record.ID
+	value := encodeMessageRecord(record)
+	return s.Db.WriteBucket(deliveryBucket, &key, &value)
+}
+
+func (s *Enclave) attemptDelivery(record MessageRecord) {
+	epl := api.DecodePayload(record.Payload)
+	if err := api.Push(epl, record.Recipient); err != nil {
+		log.WithField("recipient", record.Recipient).Errorf(
+			"Unable to deliver message %s, will retry, %v", hex.EncodeToString(record.ID), err)
+
+		backoff := initialBackoff << uint(record.Attempts)
+		record.Attempts++
+		if backoff <= 0 || backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		record.NextAttempt = time.Now().Add(backoff)
+
+		if err := s.saveRecord(record); err != nil {
+			log.Errorf("Unable to persist retry state for message %s, %v", hex.EncodeToString(record.ID), err)
+		}
+	}
+}
+
+// StartDispatcher launches the background goroutine that retries pending
+// deliveries on an exponential backoff until they're acked (via HandleAck,
+// which removes their MessageRecord) or the stop channel is closed.
+func (s *Enclave) StartDispatcher(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.dispatchPending()
+			}
+		}
+	}()
+}
+
+func (s *Enclave) dispatchPending() {
+	now := time.Now()
+
+	err := s.Db.IterateBucket(deliveryBucket, func(key, value []byte) error {
+		record := decodeMessageRecord(value)
+		if record.NextAttempt.After(now) {
+			return nil
+		}
+		s.attemptDelivery(record)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Unable to scan pending deliveries, %v", err)
+	}
+}
+
+// HandleAck processes a signed ACK from a recipient, removing the
+// corresponding MessageRecord so the dispatcher stops retrying it. Beyond
+// the signature itself, the ack's SigPubKey must match the identity key
+// pinned (via Trust) for the MessageRecord's destination url - otherwise
+// any recipient (or observer) of a multi-recipient store() could compute
+// another recipient's messageID and forge an ACK for it under a throwaway
+// keypair, silently stopping delivery to someone who never received
+// anything.
+func (s *Enclave) HandleAck(encoded []byte) error {
+	ack := api.DecodeAck(encoded)
+
+	if !verifySignature(ack.SigPubKey, ack.ID, ack.Signature) {
+		log.WithField("id", hex.EncodeToString(ack.ID)).Error("Rejecting ACK with invalid signature")
+		return errInvalidAckSignature
+	}
+
+	key := ack.ID
+	encodedRecord, err := s.Db.ReadBucket(deliveryBucket, &key)
+	if err != nil {
+		// nothing pending for this id; nothing to do
+		return nil
+	}
+	record := decodeMessageRecord(*encodedRecord)
+
+	pinnedKey, ok := s.Trust.PinnedKey(record.Recipient)
+	if !ok || !pinnedKey.Equal(ed25519.PublicKey(ack.SigPubKey)) {
+		log.WithField("recipient", record.Recipient).Error(
+			"Rejecting ACK not signed by the recipient's pinned identity key")
+		return errInvalidAckSignature
+	}
+
+	return s.Db.DeleteBucket(deliveryBucket, &key)
+}
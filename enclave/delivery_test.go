@@ -0,0 +1,90 @@
+package enclave
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"gitlab.com/eea/crux/api"
+)
+
+func newTestDeliveryEnclave(t *testing.T) *Enclave {
+	t.Helper()
+
+	trust, err := NewTrustStore("")
+	if err != nil {
+		t.Fatalf("NewTrustStore returned an error, %v", err)
+	}
+	return &Enclave{Db: newMemDataStore(), Trust: trust}
+}
+
+func TestHandleAckRemovesRecordForPinnedSigner(t *testing.T) {
+	s := newTestDeliveryEnclave(t)
+
+	recipientUrl := "https://recipient.example"
+	sigPub, sigPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate signing key, %v", err)
+	}
+	if err := s.Trust.Verify(recipientUrl, sigPub, 1); err != nil {
+		t.Fatalf("unable to pin recipient identity, %v", err)
+	}
+
+	record := MessageRecord{ID: []byte("message-id"), Recipient: recipientUrl, NextAttempt: time.Now()}
+	if err := s.saveRecord(record); err != nil {
+		t.Fatalf("unable to save message record, %v", err)
+	}
+
+	ack := api.Ack{
+		ID:        record.ID,
+		SigPubKey: sigPub,
+		Signature: ed25519.Sign(sigPriv, record.ID),
+	}
+	if err := s.HandleAck(api.EncodeAck(ack)); err != nil {
+		t.Fatalf("HandleAck rejected a legitimately signed ACK, %v", err)
+	}
+
+	key := record.ID
+	if _, err := s.Db.ReadBucket(deliveryBucket, &key); err == nil {
+		t.Fatal("MessageRecord should have been removed after a valid ACK")
+	}
+}
+
+func TestHandleAckRejectsForgedAck(t *testing.T) {
+	s := newTestDeliveryEnclave(t)
+
+	recipientUrl := "https://recipient.example"
+	sigPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate signing key, %v", err)
+	}
+	if err := s.Trust.Verify(recipientUrl, sigPub, 1); err != nil {
+		t.Fatalf("unable to pin recipient identity, %v", err)
+	}
+
+	record := MessageRecord{ID: []byte("message-id"), Recipient: recipientUrl, NextAttempt: time.Now()}
+	if err := s.saveRecord(record); err != nil {
+		t.Fatalf("unable to save message record, %v", err)
+	}
+
+	// a throwaway keypair, unrelated to the pinned recipient identity,
+	// signing the same (computable, non-secret) message id
+	forgedPub, forgedPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate forged key, %v", err)
+	}
+	forgedAck := api.Ack{
+		ID:        record.ID,
+		SigPubKey: forgedPub,
+		Signature: ed25519.Sign(forgedPriv, record.ID),
+	}
+	if err := s.HandleAck(api.EncodeAck(forgedAck)); err == nil {
+		t.Fatal("HandleAck should reject an ACK not signed by the recipient's pinned identity key")
+	}
+
+	key := record.ID
+	if _, err := s.Db.ReadBucket(deliveryBucket, &key); err != nil {
+		t.Fatal("MessageRecord should still be pending after a forged ACK")
+	}
+}
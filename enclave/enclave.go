@@ -10,6 +10,10 @@ import (
 	log "github.com/sirupsen/logrus"
 	"errors"
 	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
 )
 
 type Enclave struct {
@@ -17,6 +21,32 @@ type Enclave struct {
 	PubKeys   []nacl.Key
 	PrivKeys  []nacl.Key
 	PartyInfo api.PartyInfo
+	Identity  Identity
+	Trust     *TrustStore
+
+	// Ratchet enables per-peer forward-secret session keys (see
+	// sessions.go) for sealing recipient boxes, instead of the static
+	// long-term box keypair. With it enabled, a peer with no stored session
+	// gets one bootstrapped on the first box sealed for it (see newSession);
+	// the static scheme is then used only if that bootstrap fails.
+	Ratchet bool
+
+	// MinPoW is the minimum number of leading zero bits an envelope's
+	// proof-of-work must have to be admitted by storePayload, regardless of
+	// how that compares to its cost-scaled target. MaxTTL bounds how far in
+	// the future an envelope's Expiry may be, and DefaultTTL is the TTL
+	// applied to envelopes this node originates that don't set one.
+	MinPoW     uint
+	MaxTTL     uint32
+	DefaultTTL uint32
+
+	// Cache fronts Retrieve's reads from Db. Defaults to NoopCache when left
+	// unset, preserving the previous always-hit-the-DataStore behaviour.
+	Cache Cache
+
+	// gossipVersion is this node's high-water mark for the PartyInfo
+	// version it signs, seeded from nextGossipVersion.
+	gossipVersion uint64
 }
 
 func (s *Enclave) Store(
@@ -58,40 +88,68 @@ func (s *Enclave) store(
 
 	sealedMessage := secretbox.Seal([]byte{}, *message, nonce, masterKey)
 
-	encryptedPayload := api.EncryptedPayload {
-		Sender:         senderPubKey,
-		CipherText:     sealedMessage,
-		Nonce:          nonce[:],
-		RecipientBoxes: make([][]byte, len(recipients)),
-		RecipientNonce: recipientNonce,
-	}
+	recipientBoxes := make([][]byte, 0, len(recipients)+1)
+	recipientBoxIndex := make(map[string]int)
+	ratchetHeaders := make(map[string]api.RatchetHeader)
+	urls := make([]string, 0, len(recipients))
+	pushRecipients := make([]string, 0, len(recipients))
+
+	senderKeyHex := hex.EncodeToString((*senderPubKey)[:])
 
 	for _, recipient := range recipients {
-		if url, ok := s.PartyInfo.Recipients[recipient]; ok {
+		url, ok := s.PartyInfo.Recipients[recipient]
+		if !ok {
+			log.WithField("recipientKey", recipient).Error("Unable to resolve host")
+			continue
+		}
 
-			recipientKey, err := nacl.Load(recipient)
-			if err != nil {
-				log.WithField("recipientKey", recipientKey).Errorf(
-					"Unable to load recipient, %v", err)
-			}
+		recipientKey, err := nacl.Load(recipient)
+		if err != nil {
+			log.WithField("recipientKey", recipient).Errorf(
+				"Unable to load recipient, %v", err)
+			continue
+		}
 
-			if bytes.Equal((*recipientKey)[:], (*senderPubKey)[:]) {
-				log.WithField("recipientKey", recipientKey).Errorf(
-					"Sender cannot be recipient, %v", err)
-			}
+		if bytes.Equal((*recipientKey)[:], (*senderPubKey)[:]) {
+			log.WithField("recipientKey", recipient).Error("Sender cannot be recipient")
+			continue
+		}
 
-			sealedBox := sealPayload(recipientNonce, masterKey, recipientKey, senderPrivKey)
-			encryptedPayload.RecipientBoxes = [][]byte{ sealedBox }
-			api.Push(encryptedPayload, url)
-		} else {
-			log.WithField("recipientKey", recipient).Error("Unable to resolve host")
+		sealedBox, header := s.sealBox(senderKeyHex, recipient, recipientNonce, masterKey, recipientKey, senderPrivKey)
+		if header != nil {
+			ratchetHeaders[recipient] = *header
 		}
+
+		recipientBoxIndex[recipient] = len(recipientBoxes)
+		recipientBoxes = append(recipientBoxes, sealedBox)
+		urls = append(urls, url)
+		pushRecipients = append(pushRecipients, recipient)
 	}
 
-	sealedBox := sealPayload(recipientNonce, masterKey, senderPubKey, senderPrivKey)
-	encryptedPayload.RecipientBoxes = [][]byte{ sealedBox }
+	recipientBoxIndex[senderKeyHex] = len(recipientBoxes)
+	recipientBoxes = append(recipientBoxes, sealPayload(recipientNonce, masterKey, senderPubKey, senderPrivKey))
+
+	encryptedPayload := api.EncryptedPayload{
+		Sender:            senderPubKey,
+		CipherText:        sealedMessage,
+		Nonce:             nonce[:],
+		RecipientBoxes:    recipientBoxes,
+		RecipientBoxIndex: recipientBoxIndex,
+		RecipientNonce:    recipientNonce,
+		RatchetHeaders:    ratchetHeaders,
+	}
+	s.seal(&encryptedPayload)
 
 	encodedEpl := api.EncodePayload(encryptedPayload)
+
+	for i, url := range urls {
+		id := messageID(encryptedPayload.CipherText, pushRecipients[i])
+		if err := s.enqueueDelivery(id, encodedEpl, url); err != nil {
+			log.WithField("recipientKey", pushRecipients[i]).Errorf(
+				"Unable to queue message for delivery, %v", err)
+		}
+	}
+
 	return s.storePayload(encryptedPayload, encodedEpl)
 }
 
@@ -106,20 +164,72 @@ func (s *Enclave) verifySenderKey(publicKey nacl.Key) (nacl.Key, error) {
 
 func (s *Enclave) StorePayload(encodedEpl []byte) ([]byte, error) {
 	decoded := api.DecodePayload(encodedEpl)
-	return s.storePayload(decoded, encodedEpl)
+
+	digestHash, err := s.storePayload(decoded, encodedEpl)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sendAcks(decoded)
+
+	return digestHash, nil
+}
+
+// sendAcks acknowledges, for each of our own keys this payload was
+// addressed to, that it was successfully stored - letting the sender stop
+// retrying delivery for that recipient.
+func (s *Enclave) sendAcks(epl api.EncryptedPayload) {
+	senderKeyHex := hex.EncodeToString((*epl.Sender)[:])
+	senderUrl, ok := s.PartyInfo.Recipients[senderKeyHex]
+	if !ok {
+		return
+	}
+
+	for _, pubKey := range s.PubKeys {
+		recipientHex := hex.EncodeToString((*pubKey)[:])
+		if _, ok := epl.RecipientBoxIndex[recipientHex]; !ok {
+			continue
+		}
+
+		ack := api.Ack{
+			ID:        messageID(epl.CipherText, recipientHex),
+			SigPubKey: s.Identity.SigPubKey,
+			Signature: s.Identity.Sign(messageID(epl.CipherText, recipientHex)),
+		}
+		if err := api.SendAck(senderUrl, ack); err != nil {
+			log.WithField("url", senderUrl).Errorf("Unable to send ack, %v", err)
+		}
+	}
 }
 
 func (s *Enclave) storePayload(epl api.EncryptedPayload, encodedEpl []byte) ([]byte, error) {
 
+	if err := s.admit(epl); err != nil {
+		log.Errorf("Rejecting envelope, %v", err)
+		return nil, err
+	}
+
 	sha3Hash := sha3.New512()
 	sha3Hash.Write(epl.CipherText)
 	digestHash := sha3Hash.Sum(nil)
 
+	// invalidate any cached copy in case this digest is being overwritten
+	s.cache().Delete(digestHash)
+
 	// We don't store the digest as a base 64 encoded value
 	err := s.Db.Write(&digestHash, &encodedEpl)
 	return digestHash, err
 }
 
+// asNonce converts the wire-format (gob-friendly []byte) encoding of a nonce
+// back into the *[nacl.NonceSize]byte the nacl package's Open/Seal calls
+// expect.
+func asNonce(nonce []byte) nacl.Nonce {
+	n := new([nacl.NonceSize]byte)
+	copy(n[:], nonce)
+	return n
+}
+
 func sealPayload(
 	recipientNonce nacl.Nonce,
 	masterKey nacl.Key,
@@ -136,6 +246,10 @@ func sealPayload(
 
 func (s *Enclave) Retrieve(digestHash *[]byte) ([]byte, error) {
 
+	if cached, ok := s.cache().Get(*digestHash); ok {
+		return cached, nil
+	}
+
 	encodedEpl, err := s.Db.Read(digestHash)
 	if err != nil {
 		return nil, err
@@ -144,42 +258,117 @@ func (s *Enclave) Retrieve(digestHash *[]byte) ([]byte, error) {
 	epl := api.DecodePayload(*encodedEpl)
 
 	masterKey := new([nacl.KeySize]byte)
+	senderKeyHex := hex.EncodeToString((*epl.Sender)[:])
+
+	opened := false
+	for i, privKey := range s.PrivKeys {
+		localKeyHex := hex.EncodeToString((*s.PubKeys[i])[:])
+
+		if header, ok := epl.RatchetHeaders[localKeyHex]; ok {
+			if index, ok := epl.RecipientBoxIndex[localKeyHex]; ok {
+				if s.openRatchetBox(localKeyHex, senderKeyHex, header, epl.RecipientBoxes[index], epl.RecipientNonce, masterKey, privKey) {
+					opened = true
+					break
+				}
+			}
+		}
 
-	_, ok := secretbox.Open(masterKey[:], epl.RecipientBoxes[0], epl.RecipientNonce, s.PrivKeys[0])
-	if !ok {
+		for _, recipientBox := range epl.RecipientBoxes {
+			if _, ok := box.Open(masterKey[:0], recipientBox, epl.RecipientNonce, epl.Sender, privKey); ok {
+				opened = true
+				break
+			}
+		}
+		if opened {
+			break
+		}
+	}
+	if !opened {
 		return nil, errors.New("unable to open master key secret box")
 	}
 
-	payload := make([]byte, len(epl.CipherText))
-	_, ok = secretbox.Open(nil, epl.CipherText, epl.Nonce, masterKey)
+	payload, ok := secretbox.Open(nil, epl.CipherText, asNonce(epl.Nonce), masterKey)
 	if !ok {
-		return payload, errors.New("unable to open payload secret box")
+		return nil, errors.New("unable to open payload secret box")
 	}
 
+	s.cache().Set(*digestHash, payload)
+
 	return payload, nil
 }
 
 func (s *Enclave) Delete(digestHash *[]byte) error {
+	s.cache().Delete(*digestHash)
 	return s.Db.Delete(digestHash)
 }
 
-func (s *Enclave) UpdatePartyInfo(encoded []byte) {
-	pi := api.DecodePartyInfo(encoded)
+// nextGossipVersion returns the next strictly-increasing version this node
+// should sign its PartyInfo gossip with. It is seeded from the wall clock
+// (nanoseconds since epoch) the first time it's called rather than
+// persisted, so a restarted node's next version is still greater than any
+// it gossiped before going down - peers' TrustStore.Verify would otherwise
+// reject its updates as replays of versions it already advertised.
+func (s *Enclave) nextGossipVersion() uint64 {
+	atomic.CompareAndSwapUint64(&s.gossipVersion, 0, uint64(time.Now().UnixNano()))
+	return atomic.AddUint64(&s.gossipVersion, 1)
+}
+
+// SignPartyInfo builds and signs a gossip envelope advertising this node's
+// own url and recipient mappings, for broadcast to peers.
+func (s *Enclave) SignPartyInfo() api.SignedPartyInfo {
+	version := s.nextGossipVersion()
+	timestamp := time.Now().Unix()
+
+	message := api.CanonicalGossipEncoding(s.PartyInfo.Url, s.PartyInfo.Recipients, version, timestamp)
+
+	return api.SignedPartyInfo{
+		Url:        s.PartyInfo.Url,
+		Recipients: s.PartyInfo.Recipients,
+		Version:    version,
+		Timestamp:  timestamp,
+		SigPubKey:  s.Identity.SigPubKey,
+		Signature:  s.Identity.Sign(message),
+	}
+}
 
-	for publicKey, url := range pi.Recipients {
+// UpdatePartyInfo verifies a signed, versioned gossip envelope from a peer
+// before merging it into this node's view of the network. A record is only
+// accepted if: its signature verifies against the sigPubKey it carries; that
+// sigPubKey is the one pinned (or being pinned for the first time) for its
+// url, so peers can't masquerade as a url they don't own; and its version is
+// strictly greater than the last one accepted for that url, so replayed
+// gossip can't roll a mapping back.
+func (s *Enclave) UpdatePartyInfo(encoded []byte) error {
+	spi := api.DecodeSignedPartyInfo(encoded)
+
+	if spi.Url == s.PartyInfo.Url {
 		// we should ignore messages about ourselves
-		// in order to stop people masquerading as you, there
-		// should be a digital signature associated with each
-		// url -> node broadcast
-		if url != s.PartyInfo.Url {
+		return nil
+	}
+
+	message := api.CanonicalGossipEncoding(spi.Url, spi.Recipients, spi.Version, spi.Timestamp)
+	if !ed25519.Verify(ed25519.PublicKey(spi.SigPubKey), message, spi.Signature) {
+		log.WithField("url", spi.Url).Error("Rejecting PartyInfo gossip with invalid signature")
+		return errors.New("invalid PartyInfo gossip signature")
+	}
+
+	if err := s.Trust.Verify(spi.Url, ed25519.PublicKey(spi.SigPubKey), spi.Version); err != nil {
+		log.WithField("url", spi.Url).Errorf("Rejecting PartyInfo gossip, %v", err)
+		return err
+	}
+
+	for publicKey, url := range spi.Recipients {
+		// only accept pubkey -> url mappings signed by the identity that
+		// owns url, which we've just verified spi.Url's gossip to be
+		if url == spi.Url {
 			s.PartyInfo.Recipients[publicKey] = url
 		}
 	}
 
-	for url := range pi.Parties {
-		// we don't want to broadcast party info to ourselves
-		if url != s.PartyInfo.Url {
-			s.PartyInfo.Parties[url] = true
-		}
+	// we don't want to broadcast party info to ourselves
+	if spi.Url != s.PartyInfo.Url {
+		s.PartyInfo.Parties[spi.Url] = true
 	}
+
+	return nil
 }
@@ -0,0 +1,97 @@
+package enclave
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errInvalidAckSignature = errors.New("invalid ACK signature")
+
+// Identity is the node's long-lived signing keypair, used to authenticate
+// the PartyInfo it gossips to its peers. It is distinct from the box
+// keypairs in PubKeys/PrivKeys, which are used to seal/open payloads.
+type Identity struct {
+	SigPubKey  ed25519.PublicKey
+	SigPrivKey ed25519.PrivateKey
+}
+
+// GenerateIdentity creates a fresh signing keypair.
+func GenerateIdentity() (Identity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Identity{}, fmt.Errorf("unable to generate identity keypair: %v", err)
+	}
+	return Identity{SigPubKey: pub, SigPrivKey: priv}, nil
+}
+
+// LoadOrGenerateIdentity loads the signing keypair persisted at path,
+// generating and persisting a fresh one the first time a node runs. This
+// keypair must stay the same across restarts: TrustStore.Verify pins the
+// first SigPubKey it ever sees for a url and rejects anything else
+// afterwards, so a node that regenerated its identity on every restart
+// would permanently lock itself out of gossiping to every peer that had
+// already pinned it. Passing an empty path behaves like GenerateIdentity,
+// for callers (tests, one-off tools) that don't need persistence.
+//
+// path is taken as a plain parameter rather than read from a flag or config
+// file: this repo has no CLI/config layer anywhere (no main package, no
+// flag parsing) for it to hook into. Wiring path up to an actual
+// identity-file flag is left to whatever eventually adds that entrypoint.
+func LoadOrGenerateIdentity(path string) (Identity, error) {
+	if path != "" {
+		encoded, err := os.ReadFile(path)
+		if err == nil {
+			return decodeIdentity(string(encoded))
+		} else if !os.IsNotExist(err) {
+			return Identity{}, fmt.Errorf("unable to read identity file %s: %v", path, err)
+		}
+	}
+
+	identity, err := GenerateIdentity()
+	if err != nil {
+		return Identity{}, err
+	}
+	if path != "" {
+		if err := identity.save(path); err != nil {
+			return Identity{}, err
+		}
+	}
+	return identity, nil
+}
+
+func decodeIdentity(encoded string) (Identity, error) {
+	fields := strings.Fields(encoded)
+	if len(fields) != 2 {
+		return Identity{}, errors.New("malformed identity file")
+	}
+
+	sigPubKey, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return Identity{}, fmt.Errorf("malformed identity public key: %v", err)
+	}
+	sigPrivKey, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return Identity{}, fmt.Errorf("malformed identity private key: %v", err)
+	}
+	return Identity{SigPubKey: sigPubKey, SigPrivKey: sigPrivKey}, nil
+}
+
+func (id Identity) save(path string) error {
+	encoded := hex.EncodeToString(id.SigPubKey) + " " + hex.EncodeToString(id.SigPrivKey) + "\n"
+	return os.WriteFile(path, []byte(encoded), 0600)
+}
+
+// Sign signs message with the identity's private key: the canonical
+// encoding of a gossiped PartyInfo update, or the message id of an ACK.
+func (id Identity) Sign(message []byte) []byte {
+	return ed25519.Sign(id.SigPrivKey, message)
+}
+
+func verifySignature(sigPubKey, message, signature []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(sigPubKey), message, signature)
+}
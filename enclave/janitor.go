@@ -0,0 +1,57 @@
+package enclave
+
+import (
+	"time"
+
+	"gitlab.com/eea/crux/api"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// janitorInterval is how often the janitor sweeps the DataStore for expired
+// envelopes.
+const janitorInterval = time.Minute
+
+// StartJanitor launches the background goroutine that deletes envelopes
+// whose Expiry has passed, bounding storage growth.
+func (s *Enclave) StartJanitor(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.sweepExpired()
+			}
+		}
+	}()
+}
+
+func (s *Enclave) sweepExpired() {
+	now := uint64(time.Now().Unix())
+
+	var expired [][]byte
+	err := s.Db.IteratePayloads(func(key, value []byte) error {
+		epl := api.DecodePayload(value)
+		if epl.Expiry < now {
+			digest := make([]byte, len(key))
+			copy(digest, key)
+			expired = append(expired, digest)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Unable to scan payloads for expiry, %v", err)
+		return
+	}
+
+	for _, digest := range expired {
+		digest := digest
+		if err := s.Delete(&digest); err != nil {
+			log.WithField("digest", digest).Errorf("Unable to delete expired payload, %v", err)
+		}
+	}
+}
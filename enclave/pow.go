@@ -0,0 +1,130 @@
+package enclave
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+
+	"gitlab.com/eea/crux/api"
+)
+
+var (
+	errEnvelopeExpired      = errors.New("envelope has expired")
+	errEnvelopeExpiryTooFar = errors.New("envelope expiry is further out than MaxTTL allows")
+	errEnvelopePoWTooLow    = errors.New("envelope proof-of-work is below the minimum required")
+)
+
+// targetBits is the number of leading zero bits an envelope's proof-of-work
+// digest must have, scaling with the log2 of its cost (size * TTL) so
+// bigger or longer-lived envelopes are more expensive to mine.
+func targetBits(size int, ttl uint32) uint {
+	cost := float64(size) * float64(ttl)
+	if cost < 2 {
+		return 1
+	}
+	return uint(math.Log2(cost))
+}
+
+// envelopeDigest hashes an envelope (with its PowNonce zeroed, so mining can
+// vary the nonce without the digest depending on itself) together with a
+// candidate nonce. RecipientBoxIndex and RatchetHeaders are kept out of the
+// gob encoding, whose map order varies between encodings; instead each
+// RecipientBoxIndex entry is hashed in sorted key order together with that
+// recipient's RatchetHeader, so the same envelope always yields the same
+// digest. Headers for keys absent from RecipientBoxIndex are not covered.
+func envelopeDigest(epl api.EncryptedPayload, nonce uint64) []byte {
+	boxIndex, headers := epl.RecipientBoxIndex, epl.RatchetHeaders
+	epl.PowNonce = 0
+	epl.RecipientBoxIndex, epl.RatchetHeaders = nil, nil
+	encoded := api.EncodePayload(epl)
+
+	var nonceBytes [8]byte
+	binary.BigEndian.PutUint64(nonceBytes[:], nonce)
+
+	h := sha3.New512()
+	h.Write(encoded)
+	keys := make([]string, 0, len(boxIndex))
+	for k := range boxIndex {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s %d %+v\n", k, boxIndex[k], headers[k])
+	}
+	h.Write(nonceBytes[:])
+	return h.Sum(nil)
+}
+
+func firstBitsZero(digest []byte, bits uint) bool {
+	fullBytes := bits / 8
+	remainder := bits % 8
+
+	for i := uint(0); i < fullBytes && int(i) < len(digest); i++ {
+		if digest[i] != 0 {
+			return false
+		}
+	}
+	if remainder == 0 {
+		return true
+	}
+	mask := byte(0xFF << (8 - remainder))
+	return digest[fullBytes]&mask == 0
+}
+
+// mineNonce finds a PowNonce for epl meeting minBits (or the cost-scaled
+// target, whichever is higher).
+func mineNonce(epl api.EncryptedPayload, minBits uint) uint64 {
+	bits := targetBits(len(epl.CipherText), epl.TTL)
+	if minBits > bits {
+		bits = minBits
+	}
+	for nonce := uint64(0); ; nonce++ {
+		if firstBitsZero(envelopeDigest(epl, nonce), bits) {
+			return nonce
+		}
+	}
+}
+
+func verifyPoW(epl api.EncryptedPayload, minBits uint) bool {
+	bits := targetBits(len(epl.CipherText), epl.TTL)
+	if minBits > bits {
+		bits = minBits
+	}
+	return firstBitsZero(envelopeDigest(epl, epl.PowNonce), bits)
+}
+
+// admit applies the envelope discipline to a payload on its way into
+// storePayload: it must not have expired, its expiry must not be further
+// out than MaxTTL allows, and it must carry enough proof-of-work.
+func (s *Enclave) admit(epl api.EncryptedPayload) error {
+	now := uint64(time.Now().Unix())
+
+	if epl.Expiry < now {
+		return errEnvelopeExpired
+	}
+	if epl.Expiry > now+uint64(s.MaxTTL) {
+		return errEnvelopeExpiryTooFar
+	}
+	if !verifyPoW(epl, s.MinPoW) {
+		return errEnvelopePoWTooLow
+	}
+	return nil
+}
+
+// seal stamps an envelope this node is originating with its expiry and
+// mines the proof-of-work admission control requires.
+func (s *Enclave) seal(epl *api.EncryptedPayload) {
+	ttl := epl.TTL
+	if ttl == 0 {
+		ttl = s.DefaultTTL
+	}
+
+	epl.TTL = ttl
+	epl.Expiry = uint64(time.Now().Unix()) + uint64(ttl)
+	epl.PowNonce = mineNonce(*epl, s.MinPoW)
+}
@@ -0,0 +1,74 @@
+package enclave
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/eea/crux/api"
+)
+
+func TestMineNonceSatisfiesVerifyPoW(t *testing.T) {
+	epl := api.EncryptedPayload{CipherText: []byte("hello"), TTL: 60}
+
+	epl.PowNonce = mineNonce(epl, 4)
+
+	if !verifyPoW(epl, 4) {
+		t.Fatal("verifyPoW rejected a nonce produced by mineNonce for the same minBits")
+	}
+}
+
+func TestVerifyPoWRejectsWrongNonce(t *testing.T) {
+	epl := api.EncryptedPayload{CipherText: []byte("hello"), TTL: 60}
+	epl.PowNonce = mineNonce(epl, 4)
+
+	epl.PowNonce++
+	if verifyPoW(epl, 4) {
+		t.Fatal("verifyPoW should reject a nonce that doesn't meet the target")
+	}
+}
+
+func sealedEnvelope(s *Enclave, ttl uint32) api.EncryptedPayload {
+	epl := api.EncryptedPayload{CipherText: []byte("hello"), TTL: ttl}
+	s.seal(&epl)
+	return epl
+}
+
+func TestAdmitAcceptsFreshlySealedEnvelope(t *testing.T) {
+	s := &Enclave{MaxTTL: 3600, DefaultTTL: 60}
+	epl := sealedEnvelope(s, 0)
+
+	if err := s.admit(epl); err != nil {
+		t.Fatalf("admit rejected a freshly sealed envelope, %v", err)
+	}
+}
+
+func TestAdmitRejectsExpiredEnvelope(t *testing.T) {
+	s := &Enclave{MaxTTL: 3600, DefaultTTL: 60}
+	epl := sealedEnvelope(s, 0)
+	epl.Expiry = uint64(time.Now().Add(-time.Minute).Unix())
+
+	if err := s.admit(epl); err != errEnvelopeExpired {
+		t.Fatalf("admit(expired envelope) = %v, want %v", err, errEnvelopeExpired)
+	}
+}
+
+func TestAdmitRejectsExpiryBeyondMaxTTL(t *testing.T) {
+	s := &Enclave{MaxTTL: 60, DefaultTTL: 60}
+	epl := sealedEnvelope(s, 0)
+	epl.Expiry = uint64(time.Now().Add(time.Hour).Unix())
+
+	if err := s.admit(epl); err != errEnvelopeExpiryTooFar {
+		t.Fatalf("admit(far-future expiry) = %v, want %v", err, errEnvelopeExpiryTooFar)
+	}
+}
+
+func TestAdmitRejectsInsufficientProofOfWork(t *testing.T) {
+	s := &Enclave{MaxTTL: 3600, DefaultTTL: 60, MinPoW: 8}
+	epl := api.EncryptedPayload{CipherText: []byte("hello"), TTL: 60}
+	epl.Expiry = uint64(time.Now().Add(time.Minute).Unix())
+	epl.PowNonce = 0 // essentially never satisfies an 8-bit target by chance
+
+	if err := s.admit(epl); err != errEnvelopePoWTooLow {
+		t.Fatalf("admit(unmined envelope) = %v, want %v", err, errEnvelopePoWTooLow)
+	}
+}
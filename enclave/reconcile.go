@@ -0,0 +1,102 @@
+package enclave
+
+import (
+	"time"
+
+	"gitlab.com/eea/crux/api"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reconcileInterval is how often a node offers its known digests to its
+// peers, so a node rejoining after an outage can pull what it missed.
+const reconcileInterval = time.Minute
+
+// StartReconciler launches the background goroutine that periodically
+// offers this node's digest set to every known party and resends whichever
+// digests each party replies that it is missing. The offer fetches nothing
+// for this node itself: its own missing payloads arrive when its peers'
+// reconcilers offer to it.
+func (s *Enclave) StartReconciler(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.reconcileWithPeers()
+			}
+		}
+	}()
+}
+
+func (s *Enclave) reconcileWithPeers() {
+	digests, err := s.localDigests()
+	if err != nil {
+		log.Errorf("Unable to list local digests for reconciliation, %v", err)
+		return
+	}
+
+	offer := api.Offer{Url: s.PartyInfo.Url, Digests: digests}
+
+	for peerUrl := range s.PartyInfo.Parties {
+		request, err := api.SendOffer(peerUrl, offer)
+		if err != nil {
+			log.WithField("peer", peerUrl).Errorf("Unable to offer digests, %v", err)
+			continue
+		}
+		s.resendRequested(peerUrl, request)
+	}
+}
+
+func (s *Enclave) resendRequested(peerUrl string, request api.Request) {
+	for _, digest := range request.Digests {
+		digest := digest
+		encodedEpl, err := s.Db.Read(&digest)
+		if err != nil {
+			log.WithField("peer", peerUrl).Errorf("Unable to read requested digest, %v", err)
+			continue
+		}
+		epl := api.DecodePayload(*encodedEpl)
+		if err := api.Push(epl, peerUrl); err != nil {
+			log.WithField("peer", peerUrl).Errorf("Unable to resend requested digest, %v", err)
+		}
+	}
+}
+
+func (s *Enclave) localDigests() ([][]byte, error) {
+	var digests [][]byte
+	err := s.Db.IteratePayloads(func(key, value []byte) error {
+		digest := make([]byte, len(key))
+		copy(digest, key)
+		digests = append(digests, digest)
+		return nil
+	})
+	return digests, err
+}
+
+// HandleOffer compares an incoming digest Offer against what we already
+// have locally and returns a Request for whatever's missing.
+func (s *Enclave) HandleOffer(encoded []byte) ([]byte, error) {
+	offer := api.DecodeOffer(encoded)
+
+	have := make(map[string]bool)
+	if err := s.Db.IteratePayloads(func(key, value []byte) error {
+		have[string(key)] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var missing [][]byte
+	for _, digest := range offer.Digests {
+		if !have[string(digest)] {
+			missing = append(missing, digest)
+		}
+	}
+
+	return api.EncodeRequest(api.Request{Digests: missing}), nil
+}
@@ -0,0 +1,318 @@
+package enclave
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/kevinburke/nacl"
+	"github.com/kevinburke/nacl/box"
+	"github.com/kevinburke/nacl/secretbox"
+	"golang.org/x/crypto/hkdf"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/eea/crux/api"
+)
+
+// sessionBucket is the storage.DataStore bucket ratchet Sessions are
+// persisted in, keyed by "localPubKeyHex|remotePubKeyHex".
+const sessionBucket = "sessions"
+
+// chainState is one side (sending or receiving) of a ratchet's symmetric
+// KDF chain.
+type chainState struct {
+	ChainKey []byte
+	Index    uint32
+}
+
+// Session is the per-peer double-ratchet state that replaces a single
+// static box key once two nodes have exchanged at least one ratcheted
+// message. Compromising it only exposes messages from the current chain
+// position forward, not the whole history.
+type Session struct {
+	LocalPubKey  string
+	RemotePubKey string
+
+	RootKey []byte
+
+	DHPriv      nacl.Key
+	DHPub       nacl.Key
+	RemoteDHPub nacl.Key
+
+	SendChain       chainState
+	RecvChain       chainState
+	PrevChainLength uint32
+
+	// SkippedKeys caches message keys for chain positions that arrived out
+	// of order or were skipped, keyed by "dhPubHex|index", so they can
+	// still be used if the corresponding message shows up later.
+	SkippedKeys map[string][]byte
+}
+
+var sessionMu sync.Mutex
+
+func sessionDbKey(local, remote string) []byte {
+	return []byte(local + "|" + remote)
+}
+
+func encodeSession(s Session) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeSession(encoded []byte) Session {
+	var s Session
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&s); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Enclave) loadSession(local, remote string) (Session, bool) {
+	key := sessionDbKey(local, remote)
+	encoded, err := s.Db.ReadBucket(sessionBucket, &key)
+	if err != nil {
+		return Session{}, false
+	}
+	return decodeSession(*encoded), true
+}
+
+func (s *Enclave) saveSession(session Session) error {
+	key := sessionDbKey(session.LocalPubKey, session.RemotePubKey)
+	encoded := encodeSession(session)
+	return s.Db.WriteBucket(sessionBucket, &key, &encoded)
+}
+
+// newSession bootstraps a session the first time two peers ratchet,
+// standing in for the X3DH handshake a full Signal implementation would
+// run up front. The two sides bootstrap differently, since only one of
+// them knows the other's ephemeral key yet:
+//
+//   - The sender (localPrivKey nil) mints a fresh ephemeral keypair,
+//     advertises its public half to the peer via the message header, and
+//     derives the root key from DH(peerLongTermKey, ourEphemeralPriv).
+//   - The receiver (localPrivKey our long-term box key) doesn't have an
+//     ephemeral of its own yet; it seeds DHPriv/DHPub with the long-term
+//     keypair and leaves RootKey at its zero starting point. ratchetReceive
+//     then performs the matching DH(senderEphemeralPub, ourLongTermPriv)
+//     once it sees the sender's header - which, by the commutativity of
+//     Curve25519 DH, equals the sender's DH(peerLongTermKey, senderPriv).
+func newSession(localPubKey, remotePubKey string, remoteLongTermKey, localPrivKey nacl.Key) (Session, error) {
+	if localPrivKey != nil {
+		localPub, err := nacl.Load(localPubKey)
+		if err != nil {
+			return Session{}, err
+		}
+		return Session{
+			LocalPubKey:  localPubKey,
+			RemotePubKey: remotePubKey,
+			RootKey:      make([]byte, 32),
+			DHPriv:       localPrivKey,
+			DHPub:        localPub,
+			SkippedKeys:  make(map[string][]byte),
+		}, nil
+	}
+
+	dhPub, dhPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return Session{}, err
+	}
+
+	rootKey, chainKey := kdfRootChain(make([]byte, 32), dh(remoteLongTermKey, dhPriv))
+
+	return Session{
+		LocalPubKey:  localPubKey,
+		RemotePubKey: remotePubKey,
+		RootKey:      rootKey,
+		DHPriv:       dhPriv,
+		DHPub:        dhPub,
+		RemoteDHPub:  remoteLongTermKey,
+		SendChain:    chainState{ChainKey: chainKey, Index: 0},
+		SkippedKeys:  make(map[string][]byte),
+	}, nil
+}
+
+// dh computes the X25519 shared secret between pub and priv, reusing the
+// box package's Curve25519 precomputation rather than reimplementing it.
+func dh(pub, priv nacl.Key) []byte {
+	shared := box.Precompute(pub, priv)
+	return (*shared)[:]
+}
+
+func hkdfExpand(ikm, salt []byte) []byte {
+	out := make([]byte, 64)
+	if _, err := io.ReadFull(hkdf.New(sha512.New, ikm, salt, []byte("crux-ratchet")), out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// kdfRootChain advances the root ratchet: rootKey, chainKey = HKDF(root || DH(...)).
+func kdfRootChain(rootKey, dhOut []byte) (newRoot, newChain []byte) {
+	out := hkdfExpand(dhOut, rootKey)
+	return out[:32], out[32:64]
+}
+
+// kdfChain advances a symmetric chain one step:
+// chainKey_{n+1} = HKDF-SHA512(chainKey_n)[0:32], messageKey_n = HKDF-SHA512(chainKey_n)[32:64].
+func kdfChain(chainKey []byte) (nextChainKey, messageKey []byte) {
+	out := hkdfExpand(chainKey, nil)
+	return out[:32], out[32:64]
+}
+
+// ratchetSend derives the next sending message key for session and advances
+// its send chain. It performs no DH step itself: DH ratchet steps happen in
+// newSession and ratchetReceive.
+func ratchetSend(session *Session) (messageKey []byte, header api.RatchetHeader) {
+	nextChainKey, messageKey := kdfChain(session.SendChain.ChainKey)
+	session.SendChain.ChainKey = nextChainKey
+	session.SendChain.Index++
+
+	header = api.RatchetHeader{
+		DHPub:           (*session.DHPub)[:],
+		PrevChainLength: session.PrevChainLength,
+		MessageIndex:    session.SendChain.Index - 1,
+	}
+	return messageKey, header
+}
+
+// ratchetReceive derives the message key for an incoming header, performing
+// a DH ratchet step first if the sender has advertised a new DH public.
+func ratchetReceive(session *Session, header api.RatchetHeader) ([]byte, error) {
+	remoteDHPub, err := nacl.Load(hex.EncodeToString(header.DHPub))
+	if err != nil {
+		return nil, err
+	}
+
+	if session.RemoteDHPub == nil || !bytes.Equal((*session.RemoteDHPub)[:], (*remoteDHPub)[:]) {
+		session.PrevChainLength = session.SendChain.Index
+
+		dhPub, dhPriv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		rootKey, recvChainKey := kdfRootChain(session.RootKey, dh(remoteDHPub, session.DHPriv))
+		session.RootKey = rootKey
+		session.RecvChain = chainState{ChainKey: recvChainKey, Index: 0}
+		session.RemoteDHPub = remoteDHPub
+		session.DHPriv = dhPriv
+		session.DHPub = dhPub
+
+		rootKey, sendChainKey := kdfRootChain(session.RootKey, dh(remoteDHPub, session.DHPriv))
+		session.RootKey = rootKey
+		session.SendChain = chainState{ChainKey: sendChainKey, Index: 0}
+	}
+
+	for session.RecvChain.Index < header.MessageIndex {
+		nextChainKey, skippedKey := kdfChain(session.RecvChain.ChainKey)
+		session.SkippedKeys[skippedKeyID(header.DHPub, session.RecvChain.Index)] = skippedKey
+		session.RecvChain.ChainKey = nextChainKey
+		session.RecvChain.Index++
+	}
+
+	if key, ok := session.SkippedKeys[skippedKeyID(header.DHPub, header.MessageIndex)]; ok {
+		delete(session.SkippedKeys, skippedKeyID(header.DHPub, header.MessageIndex))
+		return key, nil
+	}
+
+	nextChainKey, messageKey := kdfChain(session.RecvChain.ChainKey)
+	session.RecvChain.ChainKey = nextChainKey
+	session.RecvChain.Index++
+	return messageKey, nil
+}
+
+func skippedKeyID(dhPub []byte, index uint32) string {
+	return hex.EncodeToString(dhPub) + "|" + strconv.FormatUint(uint64(index), 10)
+}
+
+// sealBox seals masterKey for recipientKey, using a ratcheted session key in
+// place of the static long-term keys when s.Ratchet is enabled. It returns
+// the RatchetHeader the recipient needs to derive the same message key, or
+// nil if the static long-term scheme was used.
+func (s *Enclave) sealBox(
+	localKeyHex, remoteKeyHex string,
+	nonce nacl.Nonce,
+	masterKey nacl.Key,
+	recipientKey, senderPrivKey nacl.Key) ([]byte, *api.RatchetHeader) {
+
+	if !s.Ratchet {
+		return sealPayload(nonce, masterKey, recipientKey, senderPrivKey), nil
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	session, ok := s.loadSession(localKeyHex, remoteKeyHex)
+	if !ok {
+		newSession, err := newSession(localKeyHex, remoteKeyHex, recipientKey, nil)
+		if err != nil {
+			log.WithField("recipientKey", remoteKeyHex).Errorf(
+				"Unable to bootstrap ratchet session, falling back to static keys, %v", err)
+			return sealPayload(nonce, masterKey, recipientKey, senderPrivKey), nil
+		}
+		session = newSession
+	}
+
+	messageKey, header := ratchetSend(&session)
+
+	if err := s.saveSession(session); err != nil {
+		log.WithField("recipientKey", remoteKeyHex).Errorf("Unable to persist ratchet session, %v", err)
+	}
+
+	sealedBox := secretbox.Seal([]byte{}, (*masterKey)[:], nonce, asKey(messageKey))
+	return sealedBox, &header
+}
+
+// openRatchetBox opens a box sealed under a ratcheted session key, deriving
+// the message key from header and advancing the receiving chain. localPrivKey
+// is our long-term box private key for localKeyHex, needed to bootstrap the
+// session on the first message from remoteKeyHex (see newSession).
+func (s *Enclave) openRatchetBox(localKeyHex, remoteKeyHex string, header api.RatchetHeader, sealedBox []byte, nonce nacl.Nonce, masterKey *[nacl.KeySize]byte, localPrivKey nacl.Key) bool {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	remoteKey, err := nacl.Load(remoteKeyHex)
+	if err != nil {
+		log.WithField("recipientKey", remoteKeyHex).Errorf("Unable to load sender key, %v", err)
+		return false
+	}
+
+	session, ok := s.loadSession(localKeyHex, remoteKeyHex)
+	if !ok {
+		newSession, err := newSession(localKeyHex, remoteKeyHex, remoteKey, localPrivKey)
+		if err != nil {
+			log.WithField("recipientKey", remoteKeyHex).Errorf("Unable to bootstrap ratchet session, %v", err)
+			return false
+		}
+		session = newSession
+	}
+
+	messageKey, err := ratchetReceive(&session, header)
+	if err != nil {
+		log.WithField("recipientKey", remoteKeyHex).Errorf("Unable to derive ratchet message key, %v", err)
+		return false
+	}
+
+	if err := s.saveSession(session); err != nil {
+		log.WithField("recipientKey", remoteKeyHex).Errorf("Unable to persist ratchet session, %v", err)
+	}
+
+	_, ok = secretbox.Open(masterKey[:0], sealedBox, nonce, asKey(messageKey))
+	return ok
+}
+
+func asKey(key []byte) nacl.Key {
+	k := new([nacl.KeySize]byte)
+	copy(k[:], key)
+	return k
+}
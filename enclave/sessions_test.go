@@ -0,0 +1,50 @@
+package enclave
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/kevinburke/nacl"
+	"github.com/kevinburke/nacl/box"
+)
+
+// TestRatchetBootstrapAgreesOnFirstMessage exercises the very first
+// ratcheted message between two peers that have never exchanged a
+// session: the sender bootstraps from the recipient's long-term public
+// key, and the recipient must bootstrap to the *same* message key using
+// its own long-term private key, not a freshly generated one.
+func TestRatchetBootstrapAgreesOnFirstMessage(t *testing.T) {
+	senderPub, senderPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate sender key, %v", err)
+	}
+	recipientPub, recipientPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate recipient key, %v", err)
+	}
+
+	senderKeyHex := hex.EncodeToString((*senderPub)[:])
+	recipientKeyHex := hex.EncodeToString((*recipientPub)[:])
+
+	sender := &Enclave{Db: newMemDataStore(), Ratchet: true}
+	recipient := &Enclave{Db: newMemDataStore(), Ratchet: true}
+
+	nonce := nacl.NewNonce()
+	masterKey := nacl.NewKey()
+
+	sealedBox, header := sender.sealBox(senderKeyHex, recipientKeyHex, nonce, masterKey, recipientPub, senderPriv)
+	if header == nil {
+		t.Fatal("sealBox did not return a ratchet header with Ratchet enabled")
+	}
+
+	openedMasterKey := new([nacl.KeySize]byte)
+	if !recipient.openRatchetBox(recipientKeyHex, senderKeyHex, *header, sealedBox, nonce, openedMasterKey, recipientPriv) {
+		t.Fatal("recipient was unable to open the ratcheted box with its long-term private key")
+	}
+
+	if !bytes.Equal((*masterKey)[:], openedMasterKey[:]) {
+		t.Fatalf("recovered master key %x does not match sealed master key %x", openedMasterKey[:], (*masterKey)[:])
+	}
+}
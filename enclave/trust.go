@@ -0,0 +1,131 @@
+package enclave
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TrustStore pins the signing public key and last-accepted gossip version
+// for each url a node has seen a PartyInfo update from, in the same spirit
+// as an SSH known_hosts file: the first signed update for a url is trusted
+// on sight, and every later update for that url must carry both the same
+// key and a strictly increasing version.
+type TrustStore struct {
+	path string
+
+	mu       sync.Mutex
+	pinned   map[string]ed25519.PublicKey
+	versions map[string]uint64
+}
+
+// NewTrustStore loads (or creates) the on-disk trust file at path.
+func NewTrustStore(path string) (*TrustStore, error) {
+	t := &TrustStore{
+		path:     path,
+		pinned:   make(map[string]ed25519.PublicKey),
+		versions: make(map[string]uint64),
+	}
+	if path == "" {
+		return t, nil
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TrustStore) load() error {
+	f, err := os.Open(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to open trust store %s: %v", t.path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("malformed trust store line: %q", line)
+		}
+		sigPubKey, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed trust store key for %s: %v", fields[0], err)
+		}
+		version, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("malformed trust store version for %s: %v", fields[0], err)
+		}
+		t.pinned[fields[0]] = ed25519.PublicKey(sigPubKey)
+		t.versions[fields[0]] = version
+	}
+	return scanner.Err()
+}
+
+func (t *TrustStore) save() error {
+	if t.path == "" {
+		return nil
+	}
+	f, err := os.Create(t.path)
+	if err != nil {
+		return fmt.Errorf("unable to write trust store %s: %v", t.path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for url, sigPubKey := range t.pinned {
+		if _, err := fmt.Fprintf(w, "%s %s %d\n", url, hex.EncodeToString(sigPubKey), t.versions[url]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// PinnedKey returns the signing public key pinned for url, if any.
+func (t *TrustStore) PinnedKey(url string) (ed25519.PublicKey, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sigPubKey, ok := t.pinned[url]
+	return sigPubKey, ok
+}
+
+// Verify checks a gossiped (url, sigPubKey, version) triple against what's
+// pinned for url, bootstrapping the pin on first sight. It rejects replays
+// (version not strictly greater than the last accepted one) and key
+// substitution (a different sigPubKey claiming the same url).
+func (t *TrustStore) Verify(url string, sigPubKey ed25519.PublicKey, version uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pinned, ok := t.pinned[url]; ok {
+		if !pinned.Equal(sigPubKey) {
+			return fmt.Errorf("sigPubKey for %s does not match pinned key", url)
+		}
+	} else {
+		t.pinned[url] = sigPubKey
+		if err := t.save(); err != nil {
+			return err
+		}
+	}
+
+	if last, ok := t.versions[url]; ok && version <= last {
+		return fmt.Errorf("stale or replayed PartyInfo version %d for %s (last seen %d)", version, url, last)
+	}
+	t.versions[url] = version
+	if err := t.save(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package enclave
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func genSigKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate signing key, %v", err)
+	}
+	return pub
+}
+
+func TestTrustStoreVerifyPinsOnFirstSight(t *testing.T) {
+	trust, err := NewTrustStore("")
+	if err != nil {
+		t.Fatalf("NewTrustStore returned an error, %v", err)
+	}
+
+	sigPubKey := genSigKey(t)
+	if err := trust.Verify("https://peer.example", sigPubKey, 1); err != nil {
+		t.Fatalf("Verify on an unseen url should pin and succeed, got %v", err)
+	}
+
+	pinned, ok := trust.PinnedKey("https://peer.example")
+	if !ok || !pinned.Equal(sigPubKey) {
+		t.Fatal("PinnedKey should return the key pinned by Verify")
+	}
+}
+
+func TestTrustStoreVerifyRejectsKeySubstitution(t *testing.T) {
+	trust, err := NewTrustStore("")
+	if err != nil {
+		t.Fatalf("NewTrustStore returned an error, %v", err)
+	}
+
+	url := "https://peer.example"
+	if err := trust.Verify(url, genSigKey(t), 1); err != nil {
+		t.Fatalf("initial Verify should succeed, got %v", err)
+	}
+
+	if err := trust.Verify(url, genSigKey(t), 2); err == nil {
+		t.Fatal("Verify should reject a different sigPubKey masquerading as the same url")
+	}
+}
+
+func TestTrustStoreVerifyRejectsReplayedVersion(t *testing.T) {
+	trust, err := NewTrustStore("")
+	if err != nil {
+		t.Fatalf("NewTrustStore returned an error, %v", err)
+	}
+
+	url := "https://peer.example"
+	sigPubKey := genSigKey(t)
+
+	if err := trust.Verify(url, sigPubKey, 5); err != nil {
+		t.Fatalf("initial Verify should succeed, got %v", err)
+	}
+	if err := trust.Verify(url, sigPubKey, 5); err == nil {
+		t.Fatal("Verify should reject a replayed (non-increasing) version")
+	}
+	if err := trust.Verify(url, sigPubKey, 4); err == nil {
+		t.Fatal("Verify should reject a version older than the last accepted one")
+	}
+	if err := trust.Verify(url, sigPubKey, 6); err != nil {
+		t.Fatalf("Verify should accept a strictly increasing version, got %v", err)
+	}
+}
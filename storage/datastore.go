@@ -0,0 +1,20 @@
+package storage
+
+// DataStore is the persistence interface the enclave uses to keep
+// encrypted payloads (and, increasingly, the other bits of state it needs
+// to survive a restart) keyed by digest. Write/Read/Delete operate on the
+// default bucket; the Bucket variants let callers that need a logically
+// separate keyspace (e.g. outbound delivery records) avoid colliding with
+// payload digests.
+type DataStore interface {
+	Write(key, value *[]byte) error
+	Read(key *[]byte) (*[]byte, error)
+	Delete(key *[]byte) error
+	IteratePayloads(fn func(key, value []byte) error) error
+
+	WriteBucket(bucket string, key, value *[]byte) error
+	ReadBucket(bucket string, key *[]byte) (*[]byte, error)
+	DeleteBucket(bucket string, key *[]byte) error
+	IterateBucket(bucket string, fn func(key, value []byte) error) error
+}
+